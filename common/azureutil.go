@@ -0,0 +1,136 @@
+package common
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Exported error messages
+var (
+	ErrAzureMissingKey = errors.New("Missing Azure storage account and/or account key.  " +
+		"The account and key should be passed in the URI or set in the AZURE_STORAGE_ACCOUNT and/or AZURE_STORAGE_KEY environment variables.  " +
+		"Example: az://account:account-key@my-container/path/to/object")
+	ErrAzureMissingBucketPath = errors.New("Missing Azure container or path.  " +
+		"The container and path should be passed in the URI specification.  " +
+		"Example: az://my-container/path/to/object")
+	ErrAzureUnknownScheme = errors.New("Unknown scheme in Azure URI - please use an 'az://' scheme")
+)
+
+// azureInfo contains the information needed to connect to an Azure Blob
+// Storage datastore and create or retrieve objects
+type azureInfo struct {
+	account   string
+	accessKey string
+	container string
+	path      string
+	endpoint  string
+	secure    bool
+}
+
+// isAzure does a very basic check if the given string *could* be an Azure URI
+func isAzure(s string) bool {
+	return strings.HasPrefix(s, "az://")
+}
+
+// parseAzureURI returns a struct containing all the information needed to
+// connect to an Azure Blob Storage endpoint and create or retrieve objects.
+// The data is collected from parsing the passed azureuri and environment
+// variables.
+func parseAzureURI(azureuri string) (*azureInfo, error) {
+	var info *azureInfo // parsed info
+	var u *url.URL      // parsed uri
+	var err error       // general error holder
+
+	// parse the azure path
+	if u, err = url.Parse(azureuri); err != nil {
+		return nil, err
+	}
+
+	// check scheme for giggles
+	if u.Scheme != "az" {
+		return nil, ErrAzureUnknownScheme
+	}
+
+	// init info
+	info = new(azureInfo)
+
+	// get storage account - the URI user, the ?account= query param and the
+	// AZURE_STORAGE_ACCOUNT environment variable are all checked before
+	// deciding the account is missing
+	if u.User != nil && u.User.Username() != "" {
+		info.account = u.User.Username()
+	} else if account := u.Query().Get("account"); account != "" {
+		info.account = account
+	} else {
+		// check environment
+		if info.account = os.Getenv("AZURE_STORAGE_ACCOUNT"); info.account == "" {
+			return nil, ErrAzureMissingKey
+		}
+	}
+
+	// get account key
+	if u.User != nil {
+		var ok bool // context sensitive validation holder
+		if info.accessKey, ok = u.User.Password(); !ok {
+			info.accessKey = ""
+		}
+	}
+
+	// check account key
+	if info.accessKey == "" {
+		// check environment
+		if info.accessKey = os.Getenv("AZURE_STORAGE_KEY"); info.accessKey == "" {
+			return nil, ErrAzureMissingKey
+		}
+	}
+
+	// get container
+	if info.container = u.Host; info.container == "" {
+		return nil, ErrAzureMissingBucketPath
+	}
+
+	// get path
+	if info.path = u.Path; u.Path == "" || u.Path == "/" {
+		return nil, ErrAzureMissingBucketPath
+	}
+
+	// check for endpoint override
+	if info.endpoint = u.Query().Get("endpoint"); info.endpoint == "" {
+		info.endpoint = info.account + ".blob.core.windows.net"
+	}
+
+	// check for ssl override
+	if str := u.Query().Get("secure"); str != "" {
+		if info.secure, err = strconv.ParseBool(str); err != nil {
+			return nil, err
+		}
+	} else {
+		info.secure = true
+	}
+
+	// return populated struct
+	return info, nil
+}
+
+// Bucket returns the Azure Blob Storage container name
+func (i *azureInfo) Bucket() string {
+	return i.container
+}
+
+// Path returns the object path / key within the container
+func (i *azureInfo) Path() string {
+	return i.path
+}
+
+// Endpoint returns the host the client should connect to
+func (i *azureInfo) Endpoint() string {
+	return i.endpoint
+}
+
+// Secure reports whether the connection should be made over TLS
+func (i *azureInfo) Secure() bool {
+	return i.secure
+}
@@ -0,0 +1,131 @@
+package common
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Exported error messages
+var (
+	ErrB2MissingKey = errors.New("Missing B2 account ID and/or application key.  " +
+		"The keys should be passed in the URI or set in the B2_ACCOUNT_ID and/or B2_APPLICATION_KEY environment variables.  " +
+		"Example: b2://account-id:application-key@my-bucket/path/to/object")
+	ErrB2MissingBucketPath = errors.New("Missing B2 bucket or path.  " +
+		"The bucket and path should be passed in the URI specification.  " +
+		"Example: b2://my-bucket/path/to/object")
+	ErrB2UnknownScheme = errors.New("Unknown scheme in B2 URI - please use a 'b2://' scheme")
+)
+
+// b2Info contains the information needed to connect to a Backblaze B2
+// datastore and create or retrieve objects
+type b2Info struct {
+	accountID string
+	appKey    string
+	bucket    string
+	path      string
+	endpoint  string
+	secure    bool
+}
+
+// isB2 does a very basic check if the given string *could* be a B2 URI
+func isB2(s string) bool {
+	return strings.HasPrefix(s, "b2://")
+}
+
+// parseB2URI returns a struct containing all the information needed to
+// connect to a B2 endpoint and create or retrieve objects.  The data is
+// collected from parsing the passed b2uri and environment variables.
+func parseB2URI(b2uri string) (*b2Info, error) {
+	var info *b2Info // parsed info
+	var u *url.URL   // parsed uri
+	var err error    // general error holder
+
+	// parse the b2 path
+	if u, err = url.Parse(b2uri); err != nil {
+		return nil, err
+	}
+
+	// check scheme for giggles
+	if u.Scheme != "b2" {
+		return nil, ErrB2UnknownScheme
+	}
+
+	// init info
+	info = new(b2Info)
+
+	// get account id
+	if u.User != nil && u.User.Username() != "" {
+		info.accountID = u.User.Username()
+	} else {
+		// check environment
+		if info.accountID = os.Getenv("B2_ACCOUNT_ID"); info.accountID == "" {
+			return nil, ErrB2MissingKey
+		}
+	}
+
+	// get application key
+	if u.User != nil {
+		var ok bool // context sensitive validation holder
+		if info.appKey, ok = u.User.Password(); !ok {
+			info.appKey = ""
+		}
+	}
+
+	// check application key
+	if info.appKey == "" {
+		// check environment
+		if info.appKey = os.Getenv("B2_APPLICATION_KEY"); info.appKey == "" {
+			return nil, ErrB2MissingKey
+		}
+	}
+
+	// get bucket
+	if info.bucket = u.Host; info.bucket == "" {
+		return nil, ErrB2MissingBucketPath
+	}
+
+	// get path
+	if info.path = u.Path; u.Path == "" || u.Path == "/" {
+		return nil, ErrB2MissingBucketPath
+	}
+
+	// check for endpoint override
+	if info.endpoint = u.Query().Get("endpoint"); info.endpoint == "" {
+		info.endpoint = "api.backblazeb2.com"
+	}
+
+	// check for ssl override
+	if str := u.Query().Get("secure"); str != "" {
+		if info.secure, err = strconv.ParseBool(str); err != nil {
+			return nil, err
+		}
+	} else {
+		info.secure = true
+	}
+
+	// return populated struct
+	return info, nil
+}
+
+// Bucket returns the B2 bucket name
+func (i *b2Info) Bucket() string {
+	return i.bucket
+}
+
+// Path returns the object path / key within the bucket
+func (i *b2Info) Path() string {
+	return i.path
+}
+
+// Endpoint returns the host the client should connect to
+func (i *b2Info) Endpoint() string {
+	return i.endpoint
+}
+
+// Secure reports whether the connection should be made over TLS
+func (i *b2Info) Secure() bool {
+	return i.secure
+}
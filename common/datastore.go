@@ -0,0 +1,42 @@
+package common
+
+import (
+	"errors"
+)
+
+// ErrDatastoreUnknownScheme is returned when a datastore URI does not match
+// any of the supported remote store schemes
+var ErrDatastoreUnknownScheme = errors.New("Unknown scheme in datastore URI - " +
+	"please use an 's3://', 's3n://', 'gs://', 'az://' or 'b2://' scheme")
+
+// RemoteStore is the common interface implemented by the connection info for
+// every supported cloud object storage backend.  It exposes just enough of
+// the parsed URI for the backup/restore commands to address an object
+// without caring which concrete backend they are talking to.
+type RemoteStore interface {
+	// Bucket returns the bucket, container or B2 bucket name
+	Bucket() string
+	// Path returns the object path / key within the bucket
+	Path() string
+	// Endpoint returns the host (and optional port) the client should connect to
+	Endpoint() string
+	// Secure reports whether the connection should be made over TLS
+	Secure() bool
+}
+
+// parseDatastoreURI inspects the scheme of the passed uri and dispatches to
+// the matching backend parser, returning a concrete RemoteStore client.
+func parseDatastoreURI(uri string) (RemoteStore, error) {
+	switch {
+	case isS3(uri):
+		return parseS3URI(uri)
+	case isGCS(uri):
+		return parseGCSURI(uri)
+	case isAzure(uri):
+		return parseAzureURI(uri)
+	case isB2(uri):
+		return parseB2URI(uri)
+	default:
+		return nil, ErrDatastoreUnknownScheme
+	}
+}
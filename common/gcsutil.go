@@ -0,0 +1,137 @@
+package common
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Exported error messages
+var (
+	ErrGCSMissingKey = errors.New("Missing GCS access and/or secret key.  " +
+		"The keys should be passed in the URI or set in the GOOGLE_ACCESS_KEY_ID and/or GOOGLE_SECRET_ACCESS_KEY environment variables.  " +
+		"Example: gs://access-key:secret-key@my-bucket/path/to/object")
+	ErrGCSMissingBucketPath = errors.New("Missing GCS bucket or path.  " +
+		"The bucket and path should be passed in the URI specification.  " +
+		"Example: gs://my-bucket/path/to/object")
+	ErrGCSUnknownScheme = errors.New("Unknown scheme in GCS URI - please use a 'gs://' scheme")
+)
+
+// gcsInfo contains the information needed to connect to a Google Cloud
+// Storage datastore and create or retrieve objects
+type gcsInfo struct {
+	accessKey string
+	secretKey string
+	project   string
+	bucket    string
+	path      string
+	endpoint  string
+	secure    bool
+}
+
+// isGCS does a very basic check if the given string *could* be a GCS URI
+func isGCS(s string) bool {
+	return strings.HasPrefix(s, "gs://")
+}
+
+// parseGCSURI returns a struct containing all the information needed to
+// connect to a GCS endpoint and create or retrieve objects.  The data is
+// collected from parsing the passed gcsuri and environment variables.
+func parseGCSURI(gcsuri string) (*gcsInfo, error) {
+	var info *gcsInfo // parsed info
+	var u *url.URL    // parsed uri
+	var err error     // general error holder
+
+	// parse the gcs path
+	if u, err = url.Parse(gcsuri); err != nil {
+		return nil, err
+	}
+
+	// check scheme for giggles
+	if u.Scheme != "gs" {
+		return nil, ErrGCSUnknownScheme
+	}
+
+	// init info
+	info = new(gcsInfo)
+
+	// get access key
+	if u.User != nil && u.User.Username() != "" {
+		info.accessKey = u.User.Username()
+	} else {
+		// check environment
+		if info.accessKey = os.Getenv("GOOGLE_ACCESS_KEY_ID"); info.accessKey == "" {
+			return nil, ErrGCSMissingKey
+		}
+	}
+
+	// get secret key
+	if u.User != nil {
+		var ok bool // context sensitive validation holder
+		if info.secretKey, ok = u.User.Password(); !ok {
+			info.secretKey = ""
+		}
+	}
+
+	// check secret key
+	if info.secretKey == "" {
+		// check environment
+		if info.secretKey = os.Getenv("GOOGLE_SECRET_ACCESS_KEY"); info.secretKey == "" {
+			return nil, ErrGCSMissingKey
+		}
+	}
+
+	// get project, required by the GCS API for bucket operations
+	if info.project = u.Query().Get("project"); info.project == "" {
+		info.project = os.Getenv("GOOGLE_PROJECT_ID")
+	}
+
+	// get bucket
+	if info.bucket = u.Host; info.bucket == "" {
+		return nil, ErrGCSMissingBucketPath
+	}
+
+	// get path
+	if info.path = u.Path; u.Path == "" || u.Path == "/" {
+		return nil, ErrGCSMissingBucketPath
+	}
+
+	// check for endpoint override
+	if info.endpoint = u.Query().Get("endpoint"); info.endpoint == "" {
+		info.endpoint = "storage.googleapis.com"
+	}
+
+	// check for ssl override
+	if str := u.Query().Get("secure"); str != "" {
+		if info.secure, err = strconv.ParseBool(str); err != nil {
+			return nil, err
+		}
+	} else {
+		info.secure = true
+	}
+
+	// return populated struct
+	return info, nil
+}
+
+// Bucket returns the GCS bucket name
+func (i *gcsInfo) Bucket() string {
+	return i.bucket
+}
+
+// Path returns the object path / key within the bucket
+func (i *gcsInfo) Path() string {
+	return i.path
+}
+
+// Endpoint returns the host the client should connect to
+func (i *gcsInfo) Endpoint() string {
+	return i.endpoint
+}
+
+// Secure reports whether the connection should be made over TLS
+func (i *gcsInfo) Secure() bool {
+	return i.secure
+}
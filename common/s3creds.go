@@ -0,0 +1,600 @@
+package common
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrS3NoCredentialProvider is returned when every provider in the
+// credential chain fails to yield usable access and secret keys
+var ErrS3NoCredentialProvider = errors.New("Unable to resolve S3 credentials.  " +
+	"Checked the URI, AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY, the shared credentials file, " +
+	"the web identity token (AWS_WEB_IDENTITY_TOKEN_FILE/AWS_ROLE_ARN), " +
+	"and the EC2/ECS instance metadata service - please supply credentials via one of these means.")
+
+// ec2MetadataEndpoint is the base URL of the EC2/ECS instance metadata
+// service.  Overridable in tests.
+var ec2MetadataEndpoint = "http://169.254.169.254"
+
+// metadataTimeout bounds how long we wait on the instance metadata service
+// before giving up and falling through the credential chain
+const metadataTimeout = 2 * time.Second
+
+// s3Credentials holds a resolved access key, secret key and, for temporary
+// credentials, a session token
+type s3Credentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// credentialProvider is implemented by each link in the S3 credential chain
+type credentialProvider interface {
+	// Credentials returns resolved credentials, or an error if this
+	// provider has nothing to offer
+	Credentials() (*s3Credentials, error)
+}
+
+// staticCredentialProvider returns a fixed set of credentials, either the
+// access/secret key embedded directly in the datastore URI, or an
+// already-resolved set of credentials (session token included) being
+// re-wrapped for a later stage of the chain such as STS AssumeRole
+type staticCredentialProvider struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+func (p *staticCredentialProvider) Credentials() (*s3Credentials, error) {
+	if p.accessKey == "" || p.secretKey == "" {
+		return nil, ErrS3NoCredentialProvider
+	}
+	return &s3Credentials{accessKey: p.accessKey, secretKey: p.secretKey, sessionToken: p.sessionToken}, nil
+}
+
+// envCredentialProvider reads AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY /
+// AWS_SESSION_TOKEN from the environment
+type envCredentialProvider struct{}
+
+func (p *envCredentialProvider) Credentials() (*s3Credentials, error) {
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, ErrS3NoCredentialProvider
+	}
+	return &s3Credentials{
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}, nil
+}
+
+// sharedFileCredentialProvider reads the named profile from the shared
+// credentials file, defaulting to ~/.aws/credentials and the "default"
+// profile, both of which may be overridden via the environment
+type sharedFileCredentialProvider struct {
+	profile string
+}
+
+func (p *sharedFileCredentialProvider) Credentials() (*s3Credentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, ErrS3NoCredentialProvider
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+
+	profile := p.profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	creds, err := readSharedCredentialsProfile(path, profile)
+	if err != nil {
+		return nil, ErrS3NoCredentialProvider
+	}
+	return creds, nil
+}
+
+// readSharedCredentialsProfile does a minimal INI-style parse of the shared
+// credentials file looking for the given [profile] section
+func readSharedCredentialsProfile(path, profile string) (*s3Credentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var inSection bool
+	creds := new(s3Credentials)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inSection = strings.TrimSpace(line[1:len(line)-1]) == profile
+			continue
+		}
+
+		if !inSection {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		val := strings.TrimSpace(kv[1])
+
+		switch key {
+		case "aws_access_key_id":
+			creds.accessKey = val
+		case "aws_secret_access_key":
+			creds.secretKey = val
+		case "aws_session_token":
+			creds.sessionToken = val
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if creds.accessKey == "" || creds.secretKey == "" {
+		return nil, fmt.Errorf("profile %q not found in %s", profile, path)
+	}
+	return creds, nil
+}
+
+// ec2RoleCredentialProvider retrieves temporary credentials from the EC2/ECS
+// instance metadata service, i.e. an attached instance profile or task role
+type ec2RoleCredentialProvider struct{}
+
+// ec2MetadataCredentials mirrors the JSON document returned for an IAM role
+// by the EC2/ECS metadata credentials endpoints
+type ec2MetadataCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Code            string `json:"Code"`
+}
+
+func (p *ec2RoleCredentialProvider) Credentials() (*s3Credentials, error) {
+	client := &http.Client{Timeout: metadataTimeout}
+
+	// ECS / Fargate task roles publish a relative credentials URI that is
+	// reachable on the ECS metadata endpoint without needing a role name
+	if relURI := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relURI != "" {
+		return fetchMetadataCredentials(client, "http://169.254.170.2"+relURI, "")
+	}
+
+	// otherwise fall back to the EC2 instance metadata service, using the
+	// IMDSv2 session-token flow
+	token, err := fetchIMDSv2Token(client)
+	if err != nil {
+		return nil, err
+	}
+
+	roleName, err := fetchMetadataValue(client, ec2MetadataEndpoint+"/latest/meta-data/iam/security-credentials/", token)
+	if err != nil || roleName == "" {
+		return nil, ErrS3NoCredentialProvider
+	}
+
+	return fetchMetadataCredentials(client, ec2MetadataEndpoint+"/latest/meta-data/iam/security-credentials/"+strings.TrimSpace(roleName), token)
+}
+
+func fetchIMDSv2Token(client *http.Client) (string, error) {
+	req, err := http.NewRequest(http.MethodPut, ec2MetadataEndpoint+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// IMDSv1 is still supported by most instances; proceed without a token
+		return "", nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", nil
+	}
+
+	return readMetadataBody(resp)
+}
+
+func fetchMetadataValue(client *http.Client, url, token string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata request to %s returned %s", url, resp.Status)
+	}
+
+	return readMetadataBody(resp)
+}
+
+func fetchMetadataCredentials(client *http.Client, url, token string) (*s3Credentials, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, ErrS3NoCredentialProvider
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrS3NoCredentialProvider
+	}
+
+	var doc ec2MetadataCredentials
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc.Code != "" && doc.Code != "Success" {
+		return nil, fmt.Errorf("instance metadata credentials error: %s", doc.Code)
+	}
+	if doc.AccessKeyID == "" || doc.SecretAccessKey == "" {
+		return nil, ErrS3NoCredentialProvider
+	}
+
+	return &s3Credentials{
+		accessKey:    doc.AccessKeyID,
+		secretKey:    doc.SecretAccessKey,
+		sessionToken: doc.Token,
+	}, nil
+}
+
+// maxMetadataBodySize bounds how much of an instance metadata response we
+// will buffer, as a safety net against a misbehaving endpoint
+const maxMetadataBodySize = 1 << 20 // 1MB
+
+func readMetadataBody(resp *http.Response) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMetadataBodySize))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// webIdentityCredentialProvider implements the IRSA (IAM Roles for Service
+// Accounts) flow used by EKS: a projected OIDC token file is exchanged for
+// role credentials via STS AssumeRoleWithWebIdentity
+type webIdentityCredentialProvider struct {
+	region string
+}
+
+// stsWebIdentityResponse captures the fields we care about from the STS
+// AssumeRoleWithWebIdentity XML response
+type stsWebIdentityResponse struct {
+	XMLName xml.Name `xml:"AssumeRoleWithWebIdentityResponse"`
+	Result  struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+		} `xml:"Credentials"`
+	} `xml:"AssumeRoleWithWebIdentityResult"`
+}
+
+func (p *webIdentityCredentialProvider) Credentials() (*s3Credentials, error) {
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	roleARN := os.Getenv("AWS_ROLE_ARN")
+	if tokenFile == "" || roleARN == "" {
+		return nil, ErrS3NoCredentialProvider
+	}
+
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "consul-backinator"
+	}
+
+	endpoint := "https://sts.amazonaws.com/"
+	if p.region != "" {
+		endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com/", p.region)
+	}
+
+	form := url.Values{}
+	form.Set("Action", "AssumeRoleWithWebIdentity")
+	form.Set("Version", "2011-06-15")
+	form.Set("RoleArn", roleARN)
+	form.Set("RoleSessionName", sessionName)
+	form.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+
+	resp, err := http.Post(endpoint, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sts:AssumeRoleWithWebIdentity for %s returned %s", roleARN, resp.Status)
+	}
+
+	var doc stsWebIdentityResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	return &s3Credentials{
+		accessKey:    doc.Result.Credentials.AccessKeyID,
+		secretKey:    doc.Result.Credentials.SecretAccessKey,
+		sessionToken: doc.Result.Credentials.SessionToken,
+	}, nil
+}
+
+// assumeRoleCredentialProvider wraps another provider and exchanges its
+// credentials for temporary ones scoped to roleARN via STS AssumeRole
+type assumeRoleCredentialProvider struct {
+	wrapped    credentialProvider
+	roleARN    string
+	externalID string
+	region     string
+}
+
+// stsAssumeRoleResponse captures the fields we care about from the STS
+// AssumeRole XML response
+type stsAssumeRoleResponse struct {
+	XMLName xml.Name `xml:"AssumeRoleResponse"`
+	Result  struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+		} `xml:"Credentials"`
+	} `xml:"AssumeRoleResult"`
+}
+
+func (p *assumeRoleCredentialProvider) Credentials() (*s3Credentials, error) {
+	base, err := p.wrapped.Credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := "https://sts.amazonaws.com/"
+	if p.region != "" {
+		endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com/", p.region)
+	}
+
+	form := url.Values{}
+	form.Set("Action", "AssumeRole")
+	form.Set("Version", "2011-06-15")
+	form.Set("RoleArn", p.roleARN)
+	form.Set("RoleSessionName", "consul-backinator")
+	if p.externalID != "" {
+		form.Set("ExternalId", p.externalID)
+	}
+
+	body := form.Encode()
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if base.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", base.sessionToken)
+	}
+
+	// sts:AssumeRole is a SigV4-protected API - it does not accept HTTP
+	// Basic auth, unlike the unsigned AssumeRoleWithWebIdentity call above
+	signRegion := p.region
+	if signRegion == "" {
+		signRegion = "us-east-1"
+	}
+	if err := signSigV4(req, []byte(body), base.accessKey, base.secretKey, base.sessionToken, signRegion, "sts"); err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sts:AssumeRole for %s returned %s", p.roleARN, resp.Status)
+	}
+
+	var doc stsAssumeRoleResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	return &s3Credentials{
+		accessKey:    doc.Result.Credentials.AccessKeyID,
+		secretKey:    doc.Result.Credentials.SecretAccessKey,
+		sessionToken: doc.Result.Credentials.SessionToken,
+	}, nil
+}
+
+// resolveS3Credentials walks the chained credential provider list - static
+// URI credentials, environment variables, the shared credentials file, the
+// web identity (IRSA) token, and finally EC2/ECS instance metadata -
+// returning the first set that
+// resolves.  When ?role_arn= is present on the URI, the resolved
+// credentials are further exchanged for a scoped-down set via STS
+// AssumeRole.
+func resolveS3Credentials(u *url.URL, region string) (*s3Credentials, error) {
+	var staticAccessKey, staticSecretKey string
+	if u.User != nil {
+		staticAccessKey = u.User.Username()
+		staticSecretKey, _ = u.User.Password()
+	}
+
+	chain := []credentialProvider{
+		&staticCredentialProvider{accessKey: staticAccessKey, secretKey: staticSecretKey},
+		&envCredentialProvider{},
+		&sharedFileCredentialProvider{profile: u.Query().Get("profile")},
+		&webIdentityCredentialProvider{region: region},
+		&ec2RoleCredentialProvider{},
+	}
+
+	var creds *s3Credentials
+	var err error
+	for _, provider := range chain {
+		if creds, err = provider.Credentials(); err == nil {
+			break
+		}
+	}
+	if creds == nil {
+		return nil, ErrS3NoCredentialProvider
+	}
+
+	if roleARN := u.Query().Get("role_arn"); roleARN != "" {
+		assumer := &assumeRoleCredentialProvider{
+			wrapped: &staticCredentialProvider{
+				accessKey:    creds.accessKey,
+				secretKey:    creds.secretKey,
+				sessionToken: creds.sessionToken,
+			},
+			roleARN:    roleARN,
+			externalID: u.Query().Get("external_id"),
+			region:     region,
+		}
+		if creds, err = assumer.Credentials(); err != nil {
+			return nil, err
+		}
+	}
+
+	return creds, nil
+}
+
+// signSigV4 signs req in place with AWS Signature Version 4, adding the
+// X-Amz-Date and Authorization headers needed by SigV4-protected APIs such
+// as sts:AssumeRole.  body must be the exact bytes being sent as the
+// request body (the request's existing Content-Type and any
+// X-Amz-Security-Token header are included in the signature as-is).
+func signSigV4(req *http.Request, body []byte, accessKey, secretKey, sessionToken, region, service string) error {
+	return signSigV4At(req, body, accessKey, secretKey, sessionToken, region, service, time.Now().UTC())
+}
+
+// signSigV4At is signSigV4 with an explicit signing time, split out so tests
+// can check the computed signature against a fixed, known timestamp
+func signSigV4At(req *http.Request, body []byte, accessKey, secretKey, sessionToken, region, service string, now time.Time) error {
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("Host", req.URL.Host)
+
+	signedHeaderNames := []string{"content-type", "host", "x-amz-date"}
+	if sessionToken != "" {
+		signedHeaderNames = append(signedHeaderNames, "x-amz-security-token")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaderNames {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(req.Header.Get(textprotoCanonicalHeader(name))))
+	}
+	signedHeaders := strings.Join(signedHeaderNames, ";")
+
+	payloadHash := sha256Hex(body)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		"/",
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	credentialScope := fmt.Sprintf("%s/%s/%s/aws4_request", dateStamp, region, service)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		credentialScope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := sigV4SigningKey(secretKey, dateStamp, region, service)
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, credentialScope, signedHeaders, signature,
+	))
+
+	return nil
+}
+
+// textprotoCanonicalHeader mirrors textproto/http header canonicalization
+// for the small, fixed set of header names SigV4 signing cares about
+func textprotoCanonicalHeader(name string) string {
+	switch name {
+	case "host":
+		return "Host"
+	case "content-type":
+		return "Content-Type"
+	case "x-amz-date":
+		return "X-Amz-Date"
+	case "x-amz-security-token":
+		return "X-Amz-Security-Token"
+	default:
+		return name
+	}
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sigV4SigningKey(secretKey, dateStamp, region, service string) []byte {
+	kDate := hmacSHA256([]byte("AWS4"+secretKey), dateStamp)
+	kRegion := hmacSHA256(kDate, region)
+	kService := hmacSHA256(kRegion, service)
+	return hmacSHA256(kService, "aws4_request")
+}
@@ -0,0 +1,333 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultPartSize and defaultConcurrency size multipart transfers for a
+// typical multi-GB Consul snapshot when ?part_size= / ?concurrency= are
+// not given in the datastore URI
+const (
+	defaultPartSize    int64 = 64 * 1024 * 1024 // 64MB
+	defaultConcurrency       = 8
+)
+
+// ErrS3InvalidPartSize is returned when ?part_size= cannot be parsed as a
+// plain byte count or a KB/MB/GB suffixed size
+var ErrS3InvalidPartSize = errors.New("Invalid ?part_size= value - expected a byte count such as '64MB'")
+
+// parseByteSize parses a plain integer or a value suffixed with KB, MB or GB
+// (e.g. "64MB") into a byte count
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	multiplier := int64(1)
+
+	upper := strings.ToUpper(s)
+	switch {
+	case strings.HasSuffix(upper, "GB"):
+		multiplier = 1024 * 1024 * 1024
+		s = s[:len(s)-2]
+	case strings.HasSuffix(upper, "MB"):
+		multiplier = 1024 * 1024
+		s = s[:len(s)-2]
+	case strings.HasSuffix(upper, "KB"):
+		multiplier = 1024
+		s = s[:len(s)-2]
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, ErrS3InvalidPartSize
+	}
+
+	return n * multiplier, nil
+}
+
+// s3PartUploader is implemented by an S3 client capable of driving the
+// multipart upload API.  It exists as a seam so the transfer logic below
+// can be exercised without a live S3 endpoint.
+type s3PartUploader interface {
+	CreateMultipartUpload(bucket, key string) (uploadID string, err error)
+	UploadPart(bucket, key, uploadID string, partNumber int, body []byte) (etag string, err error)
+	CompleteMultipartUpload(bucket, key, uploadID string, parts []completedPart) error
+	AbortMultipartUpload(bucket, key, uploadID string) error
+}
+
+// s3RangeGetter is implemented by an S3 client capable of serving ranged
+// GETs, used to parallelize the download of large objects
+type s3RangeGetter interface {
+	HeadObjectSize(bucket, key string) (int64, error)
+	GetObjectRange(bucket, key string, start, end int64) (io.ReadCloser, error)
+}
+
+// completedPart records the ETag S3 returns for a successfully uploaded
+// part, keyed by its 1-based part number
+type completedPart struct {
+	PartNumber int    `json:"partNumber"`
+	ETag       string `json:"etag"`
+}
+
+// uploadJournal is persisted alongside the source object so an interrupted
+// multipart upload can be resumed against the same UploadId instead of
+// restarting from scratch
+type uploadJournal struct {
+	Bucket      string          `json:"bucket"`
+	Key         string          `json:"key"`
+	UploadID    string          `json:"uploadId"`
+	PartSize    int64           `json:"partSize"`
+	Completed   []completedPart `json:"completed"`
+	mu          sync.Mutex
+	journalPath string
+}
+
+// journalPathFor derives the journal file path for a given local source
+// path, e.g. "/backups/snapshot.tgz" -> "/backups/snapshot.tgz.upload-journal"
+func journalPathFor(sourcePath string) string {
+	return sourcePath + ".upload-journal"
+}
+
+// loadUploadJournal reads a previously written journal, returning nil
+// (without error) when none exists
+func loadUploadJournal(journalPath string) (*uploadJournal, error) {
+	data, err := os.ReadFile(journalPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	j := new(uploadJournal)
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, err
+	}
+	j.journalPath = journalPath
+	return j, nil
+}
+
+// save persists the journal to disk so a later run can resume
+func (j *uploadJournal) save() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(j.journalPath, data, 0600)
+}
+
+// addCompletedPart records a finished part and flushes the journal
+func (j *uploadJournal) addCompletedPart(part completedPart) error {
+	j.mu.Lock()
+	j.Completed = append(j.Completed, part)
+	j.mu.Unlock()
+	return j.save()
+}
+
+// isPartDone reports whether partNumber already has a recorded ETag
+func (j *uploadJournal) isPartDone(partNumber int) (string, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, p := range j.Completed {
+		if p.PartNumber == partNumber {
+			return p.ETag, true
+		}
+	}
+	return "", false
+}
+
+// remove deletes the journal file once the upload completes successfully
+func (j *uploadJournal) remove() error {
+	return os.Remove(j.journalPath)
+}
+
+// MultipartUpload uploads the contents of src to the bucket/key addressed by
+// info, splitting it into info.partSize chunks and transferring up to
+// info.concurrency of them in parallel.  If an upload journal already exists
+// for sourcePath (left behind by an interrupted previous run), the existing
+// UploadId and completed parts are reused instead of starting over, and the
+// chunks are sized by the part size recorded in that journal.
+func MultipartUpload(client s3PartUploader, info *s3Info, sourcePath string, src io.ReaderAt, size int64) error {
+	journalPath := journalPathFor(sourcePath)
+
+	journal, err := loadUploadJournal(journalPath)
+	if err != nil {
+		return err
+	}
+
+	// a journal left behind by a previous run only applies if it targets the
+	// same bucket/key we're uploading to now - otherwise discard it and
+	// start a fresh upload rather than resuming against the wrong object
+	if journal != nil && (journal.Bucket != info.bucket || journal.Key != info.path) {
+		journal = nil
+	}
+
+	if journal == nil {
+		uploadID, err := client.CreateMultipartUpload(info.bucket, info.path)
+		if err != nil {
+			return err
+		}
+		journal = &uploadJournal{
+			Bucket:      info.bucket,
+			Key:         info.path,
+			UploadID:    uploadID,
+			PartSize:    info.partSize,
+			journalPath: journalPath,
+		}
+		if err := journal.save(); err != nil {
+			return err
+		}
+	}
+
+	partCount := int((size + journal.PartSize - 1) / journal.PartSize)
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		sem      = make(chan struct{}, info.concurrency)
+	)
+
+	for partNumber := 1; partNumber <= partCount; partNumber++ {
+		if _, done := journal.isPartDone(partNumber); done {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(partNumber int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			offset := int64(partNumber-1) * journal.PartSize
+			length := journal.PartSize
+			if remaining := size - offset; remaining < length {
+				length = remaining
+			}
+
+			buf := make([]byte, length)
+			if _, err := src.ReadAt(buf, offset); err != nil && err != io.EOF {
+				errOnce.Do(func() { firstErr = err })
+				return
+			}
+
+			etag, err := client.UploadPart(info.bucket, info.path, journal.UploadID, partNumber, buf)
+			if err != nil {
+				errOnce.Do(func() { firstErr = err })
+				return
+			}
+
+			if err := journal.addCompletedPart(completedPart{PartNumber: partNumber, ETag: etag}); err != nil {
+				errOnce.Do(func() { firstErr = err })
+			}
+		}(partNumber)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	parts := make([]completedPart, partCount)
+	for i := 1; i <= partCount; i++ {
+		etag, _ := journal.isPartDone(i)
+		parts[i-1] = completedPart{PartNumber: i, ETag: etag}
+	}
+
+	if err := client.CompleteMultipartUpload(info.bucket, info.path, journal.UploadID, parts); err != nil {
+		return err
+	}
+
+	return journal.remove()
+}
+
+// MultipartDownload fetches the bucket/key addressed by info into dst, using
+// concurrent ranged GETs sized at info.partSize when the object exceeds a
+// single part, and falling back to a single whole-object GET otherwise.
+func MultipartDownload(client s3RangeGetter, info *s3Info, dst io.WriterAt) error {
+	size, err := client.HeadObjectSize(info.bucket, info.path)
+	if err != nil {
+		return err
+	}
+
+	if size == 0 {
+		return nil
+	}
+
+	if size <= info.partSize {
+		body, err := client.GetObjectRange(info.bucket, info.path, 0, size-1)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		return copyRangeTo(dst, 0, body)
+	}
+
+	partCount := int((size + info.partSize - 1) / info.partSize)
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		sem      = make(chan struct{}, info.concurrency)
+	)
+
+	for i := 0; i < partCount; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			start := int64(i) * info.partSize
+			end := start + info.partSize - 1
+			if end > size-1 {
+				end = size - 1
+			}
+
+			body, err := client.GetObjectRange(info.bucket, info.path, start, end)
+			if err != nil {
+				errOnce.Do(func() { firstErr = fmt.Errorf("part %d: %w", i+1, err) })
+				return
+			}
+			defer body.Close()
+
+			if err := copyRangeTo(dst, start, body); err != nil {
+				errOnce.Do(func() { firstErr = fmt.Errorf("part %d: %w", i+1, err) })
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
+
+// copyRangeTo copies all of src into dst starting at the given offset
+func copyRangeTo(dst io.WriterAt, offset int64, src io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.WriteAt(buf[:n], offset); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
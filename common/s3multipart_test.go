@@ -0,0 +1,256 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// fakePartUploader is an in-memory s3PartUploader used to exercise
+// MultipartUpload without a live S3 endpoint
+type fakePartUploader struct {
+	mu          sync.Mutex
+	uploadID    string
+	parts       map[int][]byte
+	failPart    int // if non-zero, UploadPart fails for this part number once
+	failedOnce  bool
+	createCalls int
+}
+
+func (f *fakePartUploader) CreateMultipartUpload(bucket, key string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.createCalls++
+	if f.uploadID == "" {
+		f.uploadID = "test-upload-id"
+	}
+	f.parts = map[int][]byte{}
+	return f.uploadID, nil
+}
+
+func (f *fakePartUploader) UploadPart(bucket, key, uploadID string, partNumber int, body []byte) (string, error) {
+	if partNumber == f.failPart && !f.failedOnce {
+		f.failedOnce = true
+		return "", errors.New("simulated transient failure")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.parts == nil {
+		f.parts = map[int][]byte{}
+	}
+	buf := make([]byte, len(body))
+	copy(buf, body)
+	f.parts[partNumber] = buf
+	return fmt.Sprintf("etag-%d", partNumber), nil
+}
+
+func (f *fakePartUploader) CompleteMultipartUpload(bucket, key, uploadID string, parts []completedPart) error {
+	return nil
+}
+
+func (f *fakePartUploader) AbortMultipartUpload(bucket, key, uploadID string) error {
+	return nil
+}
+
+func (f *fakePartUploader) assembled(partCount int) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []byte
+	for i := 1; i <= partCount; i++ {
+		out = append(out, f.parts[i]...)
+	}
+	return out
+}
+
+func TestMultipartUpload(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 25)
+	src := bytes.NewReader(data)
+
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "snapshot.tgz")
+	if err := os.WriteFile(sourcePath, data, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	info := &s3Info{bucket: "my-bucket", path: "/snapshot.tgz", partSize: 10, concurrency: 2}
+	client := &fakePartUploader{}
+
+	if err := MultipartUpload(client, info, sourcePath, src, int64(len(data))); err != nil {
+		t.Fatalf("MultipartUpload: %v", err)
+	}
+
+	partCount := 3 // 25 bytes / 10-byte parts -> 10, 10, 5
+	if got := client.assembled(partCount); !bytes.Equal(got, data) {
+		t.Errorf("assembled upload = %q, want %q", got, data)
+	}
+
+	if _, err := os.Stat(journalPathFor(sourcePath)); !os.IsNotExist(err) {
+		t.Errorf("journal file should be removed after a successful upload, stat err = %v", err)
+	}
+}
+
+func TestMultipartUploadResumesFromExistingJournal(t *testing.T) {
+	data := bytes.Repeat([]byte("y"), 20)
+
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "snapshot.tgz")
+	if err := os.WriteFile(sourcePath, data, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	info := &s3Info{bucket: "my-bucket", path: "/snapshot.tgz", partSize: 10, concurrency: 1}
+
+	journal := &uploadJournal{
+		Bucket:      info.bucket,
+		Key:         info.path,
+		UploadID:    "resumed-upload-id",
+		PartSize:    info.partSize,
+		Completed:   []completedPart{{PartNumber: 1, ETag: "etag-1"}},
+		journalPath: journalPathFor(sourcePath),
+	}
+	if err := journal.save(); err != nil {
+		t.Fatalf("journal.save: %v", err)
+	}
+
+	client := &fakePartUploader{uploadID: "resumed-upload-id"}
+	if err := MultipartUpload(client, info, sourcePath, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("MultipartUpload: %v", err)
+	}
+
+	client.mu.Lock()
+	_, part1Uploaded := client.parts[1]
+	client.mu.Unlock()
+	if part1Uploaded {
+		t.Error("part 1 was re-uploaded even though the journal already recorded it as complete")
+	}
+	if client.createCalls != 0 {
+		t.Errorf("CreateMultipartUpload called %d times, want 0 when resuming", client.createCalls)
+	}
+}
+
+func TestMultipartUploadDiscardsJournalForDifferentDestination(t *testing.T) {
+	data := bytes.Repeat([]byte("z"), 15)
+
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "snapshot.tgz")
+	if err := os.WriteFile(sourcePath, data, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// journal left behind by a run against a different bucket
+	staleJournal := &uploadJournal{
+		Bucket:      "old-bucket",
+		Key:         "/snapshot.tgz",
+		UploadID:    "stale-upload-id",
+		PartSize:    10,
+		Completed:   []completedPart{{PartNumber: 1, ETag: "etag-1"}},
+		journalPath: journalPathFor(sourcePath),
+	}
+	if err := staleJournal.save(); err != nil {
+		t.Fatalf("journal.save: %v", err)
+	}
+
+	info := &s3Info{bucket: "new-bucket", path: "/snapshot.tgz", partSize: 10, concurrency: 1}
+	client := &fakePartUploader{}
+
+	if err := MultipartUpload(client, info, sourcePath, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("MultipartUpload: %v", err)
+	}
+
+	if client.createCalls != 1 {
+		t.Errorf("CreateMultipartUpload called %d times, want 1 (stale journal should have been discarded)", client.createCalls)
+	}
+	if client.uploadID == "stale-upload-id" {
+		t.Error("upload reused the stale journal's UploadID for a different bucket")
+	}
+}
+
+// fakeRangeGetter is an in-memory s3RangeGetter used to exercise
+// MultipartDownload without a live S3 endpoint
+type fakeRangeGetter struct {
+	data []byte
+}
+
+func (f *fakeRangeGetter) HeadObjectSize(bucket, key string) (int64, error) {
+	return int64(len(f.data)), nil
+}
+
+func (f *fakeRangeGetter) GetObjectRange(bucket, key string, start, end int64) (io.ReadCloser, error) {
+	if start < 0 || end >= int64(len(f.data)) || start > end {
+		return nil, fmt.Errorf("invalid range %d-%d for %d byte object", start, end, len(f.data))
+	}
+	return io.NopCloser(bytes.NewReader(f.data[start : end+1])), nil
+}
+
+type memWriterAt struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	need := off + int64(len(p))
+	if int64(len(w.buf)) < need {
+		grown := make([]byte, need)
+		copy(grown, w.buf)
+		w.buf = grown
+	}
+	copy(w.buf[off:], p)
+	return len(p), nil
+}
+
+func TestMultipartDownload(t *testing.T) {
+	data := bytes.Repeat([]byte("d"), 25)
+	client := &fakeRangeGetter{data: data}
+	info := &s3Info{bucket: "my-bucket", path: "/snapshot.tgz", partSize: 10, concurrency: 2}
+
+	dst := &memWriterAt{}
+	if err := MultipartDownload(client, info, dst); err != nil {
+		t.Fatalf("MultipartDownload: %v", err)
+	}
+	if !bytes.Equal(dst.buf, data) {
+		t.Errorf("downloaded = %q, want %q", dst.buf, data)
+	}
+}
+
+func TestMultipartDownloadSingleRangeBelowPartSize(t *testing.T) {
+	data := []byte("small object")
+	client := &fakeRangeGetter{data: data}
+	info := &s3Info{bucket: "my-bucket", path: "/snapshot.tgz", partSize: 1024, concurrency: 2}
+
+	dst := &memWriterAt{}
+	if err := MultipartDownload(client, info, dst); err != nil {
+		t.Fatalf("MultipartDownload: %v", err)
+	}
+	if !bytes.Equal(dst.buf, data) {
+		t.Errorf("downloaded = %q, want %q", dst.buf, data)
+	}
+}
+
+func TestMultipartDownloadZeroByteObject(t *testing.T) {
+	client := &fakeRangeGetter{data: nil}
+	info := &s3Info{bucket: "my-bucket", path: "/empty", partSize: 1024, concurrency: 2}
+
+	dst := &memWriterAt{}
+	if err := MultipartDownload(client, info, dst); err != nil {
+		t.Fatalf("MultipartDownload on a zero-byte object should not error, got: %v", err)
+	}
+	if len(dst.buf) != 0 {
+		t.Errorf("downloaded %d bytes for a zero-byte object, want 0", len(dst.buf))
+	}
+}
+
+func TestJournalPathFor(t *testing.T) {
+	// journalPathFor is a small pure function exercised indirectly above;
+	// check its naming convention directly as well
+	if got, want := journalPathFor("/backups/snapshot.tgz"), "/backups/snapshot.tgz.upload-journal"; got != want {
+		t.Errorf("journalPathFor = %q, want %q", got, want)
+	}
+}
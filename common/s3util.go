@@ -1,6 +1,8 @@
 package common
 
 import (
+	"crypto/md5"
+	"encoding/base64"
 	"errors"
 	"net/url"
 	"os"
@@ -16,20 +18,42 @@ var (
 	ErrS3MissingBucketPath = errors.New("Missing S3 bucket or path.  " +
 		"The bucket and path should be passed in the URI specification.  " +
 		"Example: s3://my-bucket/path/to/object")
-	ErrS3UnknownScheme    = errors.New("Unknown scheme in S3 URI - please use an 's3://' or 's3n://' scheme")
-	ErrCreateUnknownError = errors.New("Failed to create bucket on S3 datastore.") // This shouldn't happen
+	ErrS3UnknownScheme         = errors.New("Unknown scheme in S3 URI - please use an 's3://' or 's3n://' scheme")
+	ErrCreateUnknownError      = errors.New("Failed to create bucket on S3 datastore.") // This shouldn't happen
+	ErrS3InvalidSSE            = errors.New("Invalid ?sse= value - must be one of 'AES256', 'aws:kms' or 'C'")
+	ErrS3SSECMissingKey        = errors.New("?sse=C requires a base64 encoded customer key in ?sse_customer_key=")
+	ErrS3SSEConflictingOptions = errors.New("?kms_key_id= and ?sse_customer_key= are mutually exclusive with each other " +
+		"and only apply to ?sse=aws:kms and ?sse=C respectively")
+	ErrS3InvalidSignature   = errors.New("Invalid ?signature= value - must be one of 'v2' or 'v4'")
+	ErrS3InvalidConcurrency = errors.New("Invalid ?concurrency= value - must be a positive integer")
+)
+
+// Server-side encryption modes accepted in the ?sse= query parameter
+const (
+	sseAES256 = "AES256"
+	sseKMS    = "aws:kms"
+	sseC      = "C"
 )
 
 // s3Info contains the information needed to connect to an S3
 // datastore and create or retrieve objects
 type s3Info struct {
-	accessKey string
-	secretKey string
-	region    string
-	bucket    string
-	path      string
-	endpoint  string
-	secure    bool
+	accessKey         string
+	secretKey         string
+	sessionToken      string
+	region            string
+	bucket            string
+	path              string
+	endpoint          string
+	secure            bool
+	pathStyle         bool   // force endpoint/bucket/key addressing instead of bucket.endpoint/key
+	signature         string // "v2" or "v4", defaults to "v4"
+	sseMode           string // "", "AES256", "aws:kms" or "C"
+	sseKMSKeyID       string // optional CMK id/alias/arn when sseMode is "aws:kms"
+	sseCustomerKey    []byte // decoded SSE-C key when sseMode is "C"
+	sseCustomerKeyMD5 string // base64 MD5 digest of sseCustomerKey, required by S3 alongside the key
+	partSize          int64  // multipart upload / ranged download part size in bytes
+	concurrency       int    // number of parts to transfer in parallel
 }
 
 // isS3 does a very basic check if the given string *could* be an S3 URI
@@ -37,6 +61,61 @@ func isS3(s string) bool {
 	return strings.HasPrefix(s, "s3://") || strings.HasPrefix(s, "s3n://")
 }
 
+// Bucket returns the S3 bucket name
+func (i *s3Info) Bucket() string {
+	return i.bucket
+}
+
+// Path returns the object path / key within the bucket
+func (i *s3Info) Path() string {
+	return i.path
+}
+
+// Endpoint returns the host the client should connect to
+func (i *s3Info) Endpoint() string {
+	return i.endpoint
+}
+
+// Secure reports whether the connection should be made over TLS
+func (i *s3Info) Secure() bool {
+	return i.secure
+}
+
+// PathStyle reports whether the client should address objects as
+// endpoint/bucket/key instead of bucket.endpoint/key
+func (i *s3Info) PathStyle() bool {
+	return i.pathStyle
+}
+
+// Signature returns the signature version to use when signing requests,
+// either "v2" or "v4"
+func (i *s3Info) Signature() string {
+	return i.signature
+}
+
+// SSEMode returns the server-side encryption mode for this object: "" (none),
+// "AES256", "aws:kms" or "C"
+func (i *s3Info) SSEMode() string {
+	return i.sseMode
+}
+
+// SSEKMSKeyID returns the customer managed KMS key id/alias/arn to use when
+// SSEMode is "aws:kms".  An empty string means the bucket's default CMK.
+func (i *s3Info) SSEKMSKeyID() string {
+	return i.sseKMSKeyID
+}
+
+// SSECustomerKey returns the decoded SSE-C key to use when SSEMode is "C"
+func (i *s3Info) SSECustomerKey() []byte {
+	return i.sseCustomerKey
+}
+
+// SSECustomerKeyMD5 returns the base64 MD5 digest of SSECustomerKey, required
+// by S3 alongside the key itself on every SSE-C request
+func (i *s3Info) SSECustomerKeyMD5() string {
+	return i.sseCustomerKeyMD5
+}
+
 // parseS3URI returns a struct containing all the information needed to connect
 // to an S3 endpoing and create or retrieve objects.  The data is collected from
 // parsing the passed s3uri and environment variables.
@@ -58,39 +137,25 @@ func parseS3URI(s3uri string) (*s3Info, error) {
 	// init info
 	info = new(s3Info)
 
-	// get access key
-	if u.User != nil && u.User.Username() != "" {
-		info.accessKey = u.User.Username()
-	} else {
-		// check environment
-		if info.accessKey = os.Getenv("AWS_ACCESS_KEY_ID"); info.accessKey == "" {
-			return nil, ErrS3MissingKey
-		}
-	}
-
-	// get secret key
-	if u.User != nil {
-		var ok bool // context sensitive validation holder
-		if info.secretKey, ok = u.User.Password(); !ok {
-			info.secretKey = ""
-		}
-	}
-
-	// check secret key
-	if info.secretKey == "" {
-		// check environment
-		if info.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY"); info.secretKey == "" {
-			return nil, ErrS3MissingKey
-		}
-	}
-
-	// get region
+	// get region up front since it is needed to resolve the STS endpoint
+	// used by the role_arn leg of the credential chain
 	if info.region = u.Query().Get("region"); info.region == "" {
 		if info.region = os.Getenv("AWS_REGION"); info.region == "" {
 			info.region = "us-east-1"
 		}
 	}
 
+	// resolve credentials by walking the static -> env -> shared file ->
+	// web identity -> instance metadata chain, optionally exchanging the
+	// result for a scoped-down role via STS AssumeRole
+	creds, err := resolveS3Credentials(u, info.region)
+	if err != nil {
+		return nil, err
+	}
+	info.accessKey = creds.accessKey
+	info.secretKey = creds.secretKey
+	info.sessionToken = creds.sessionToken
+
 	// get bucket
 	if info.bucket = u.Host; info.bucket == "" {
 		return nil, ErrS3MissingBucketPath
@@ -101,18 +166,113 @@ func parseS3URI(s3uri string) (*s3Info, error) {
 		return nil, ErrS3MissingBucketPath
 	}
 
-	// check for endpoint override
+	// check for endpoint override.  The endpoint may optionally carry its own
+	// http:// or https:// scheme (as opposed to a bare host), in which case
+	// that scheme takes precedence over ?secure= when deciding info.secure
+	var endpointScheme string
 	if info.endpoint = u.Query().Get("endpoint"); info.endpoint == "" {
 		info.endpoint = "s3.amazonaws.com"
+	} else if strings.Contains(info.endpoint, "://") {
+		// only treat the endpoint as scheme-qualified when it actually
+		// contains "://" - otherwise a bare "host:port" (e.g. MinIO's
+		// "localhost:9000") parses with "host" taken as the URL scheme and
+		// an empty Host, which would silently zero out the endpoint
+		if endpointURL, perr := url.Parse(info.endpoint); perr == nil && endpointURL.Scheme != "" {
+			endpointScheme = endpointURL.Scheme
+			info.endpoint = endpointURL.Host
+		}
 	}
 
 	// check for ssl override
-	if str := u.Query().Get("secure"); str != "" {
-		if info.secure, err = strconv.ParseBool(str); err != nil {
+	switch {
+	case endpointScheme == "http":
+		info.secure = false
+	case endpointScheme == "https":
+		info.secure = true
+	default:
+		if str := u.Query().Get("secure"); str != "" {
+			if info.secure, err = strconv.ParseBool(str); err != nil {
+				return nil, err
+			}
+		} else {
+			info.secure = true
+		}
+	}
+
+	// check for path-style (endpoint/bucket/key) addressing, required by
+	// MinIO, Ceph RadosGW and localstack
+	if str := u.Query().Get("path_style"); str != "" {
+		if info.pathStyle, err = strconv.ParseBool(str); err != nil {
+			return nil, err
+		}
+	}
+
+	// check for a signature version override, needed by older S3-compatible
+	// stores that don't understand SigV4
+	if info.signature = u.Query().Get("signature"); info.signature == "" {
+		info.signature = "v4"
+	} else if info.signature != "v2" && info.signature != "v4" {
+		return nil, ErrS3InvalidSignature
+	}
+
+	// check for multipart transfer overrides
+	if str := u.Query().Get("part_size"); str != "" {
+		if info.partSize, err = parseByteSize(str); err != nil {
 			return nil, err
 		}
 	} else {
-		info.secure = true
+		info.partSize = defaultPartSize
+	}
+
+	if str := u.Query().Get("concurrency"); str != "" {
+		var concurrency int
+		if concurrency, err = strconv.Atoi(str); err != nil {
+			return nil, err
+		}
+		if concurrency < 1 {
+			return nil, ErrS3InvalidConcurrency
+		}
+		info.concurrency = concurrency
+	} else {
+		info.concurrency = defaultConcurrency
+	}
+
+	// check for server-side encryption options.  ?kms_key_id= and
+	// ?sse_customer_key= are mutually exclusive and only meaningful for
+	// their respective ?sse= mode, so reject either one showing up
+	// anywhere else rather than silently ignoring it.
+	kmsKeyID := u.Query().Get("kms_key_id")
+	customerKey := u.Query().Get("sse_customer_key")
+
+	info.sseMode = u.Query().Get("sse")
+	switch info.sseMode {
+	case "":
+		if kmsKeyID != "" || customerKey != "" {
+			return nil, ErrS3SSEConflictingOptions
+		}
+	case sseAES256:
+		if kmsKeyID != "" || customerKey != "" {
+			return nil, ErrS3SSEConflictingOptions
+		}
+	case sseKMS:
+		if customerKey != "" {
+			return nil, ErrS3SSEConflictingOptions
+		}
+		info.sseKMSKeyID = kmsKeyID
+	case sseC:
+		if kmsKeyID != "" {
+			return nil, ErrS3SSEConflictingOptions
+		}
+		if customerKey == "" {
+			return nil, ErrS3SSECMissingKey
+		}
+		if info.sseCustomerKey, err = base64.StdEncoding.DecodeString(customerKey); err != nil {
+			return nil, err
+		}
+		digest := md5.Sum(info.sseCustomerKey)
+		info.sseCustomerKeyMD5 = base64.StdEncoding.EncodeToString(digest[:])
+	default:
+		return nil, ErrS3InvalidSSE
 	}
 
 	// return populated struct
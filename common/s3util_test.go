@@ -0,0 +1,222 @@
+package common
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"testing"
+)
+
+const testS3Creds = "access-key:secret-key@"
+
+func TestParseS3URIBasic(t *testing.T) {
+	info, err := parseS3URI("s3://" + testS3Creds + "my-bucket/path/to/object")
+	if err != nil {
+		t.Fatalf("parseS3URI: %v", err)
+	}
+
+	if info.Bucket() != "my-bucket" {
+		t.Errorf("Bucket() = %q, want %q", info.Bucket(), "my-bucket")
+	}
+	if info.Path() != "/path/to/object" {
+		t.Errorf("Path() = %q, want %q", info.Path(), "/path/to/object")
+	}
+	if info.Endpoint() != "s3.amazonaws.com" {
+		t.Errorf("Endpoint() = %q, want default", info.Endpoint())
+	}
+	if !info.Secure() {
+		t.Error("Secure() = false, want true by default")
+	}
+	if info.region != "us-east-1" {
+		t.Errorf("region = %q, want default us-east-1", info.region)
+	}
+	if info.signature != "v4" {
+		t.Errorf("signature = %q, want default v4", info.signature)
+	}
+	if info.partSize != defaultPartSize {
+		t.Errorf("partSize = %d, want default %d", info.partSize, defaultPartSize)
+	}
+	if info.concurrency != defaultConcurrency {
+		t.Errorf("concurrency = %d, want default %d", info.concurrency, defaultConcurrency)
+	}
+}
+
+func TestParseS3URIMissingBucketOrPath(t *testing.T) {
+	cases := []string{
+		"s3://" + testS3Creds,
+		"s3://" + testS3Creds + "my-bucket",
+		"s3://" + testS3Creds + "my-bucket/",
+	}
+	for _, uri := range cases {
+		if _, err := parseS3URI(uri); err != ErrS3MissingBucketPath {
+			t.Errorf("parseS3URI(%q) err = %v, want ErrS3MissingBucketPath", uri, err)
+		}
+	}
+}
+
+func TestParseS3URIUnknownScheme(t *testing.T) {
+	if _, err := parseS3URI("ftp://" + testS3Creds + "my-bucket/path"); err != ErrS3UnknownScheme {
+		t.Errorf("err = %v, want ErrS3UnknownScheme", err)
+	}
+}
+
+func TestParseS3URIEndpointHTTPScheme(t *testing.T) {
+	info, err := parseS3URI("s3://" + testS3Creds + "my-bucket/path?endpoint=http://minio.local:9000")
+	if err != nil {
+		t.Fatalf("parseS3URI: %v", err)
+	}
+	if info.Endpoint() != "minio.local:9000" {
+		t.Errorf("Endpoint() = %q, want %q", info.Endpoint(), "minio.local:9000")
+	}
+	if info.Secure() {
+		t.Error("Secure() = true, want false for an http:// endpoint")
+	}
+}
+
+func TestParseS3URIEndpointBareHostPort(t *testing.T) {
+	// regression test: a bare "host:port" endpoint (no http:// or https://
+	// prefix) must not be misparsed as a URL with "host" as its scheme,
+	// which would silently zero out the endpoint
+	info, err := parseS3URI("s3://" + testS3Creds + "my-bucket/path?endpoint=localhost:9000&secure=false")
+	if err != nil {
+		t.Fatalf("parseS3URI: %v", err)
+	}
+	if info.Endpoint() != "localhost:9000" {
+		t.Errorf("Endpoint() = %q, want %q", info.Endpoint(), "localhost:9000")
+	}
+	if info.Secure() {
+		t.Error("Secure() = true, want false from ?secure=false")
+	}
+}
+
+func TestParseS3URIPathStyleAndSignature(t *testing.T) {
+	info, err := parseS3URI("s3://" + testS3Creds + "my-bucket/path?path_style=true&signature=v2")
+	if err != nil {
+		t.Fatalf("parseS3URI: %v", err)
+	}
+	if !info.PathStyle() {
+		t.Error("PathStyle() = false, want true")
+	}
+	if info.Signature() != "v2" {
+		t.Errorf("Signature() = %q, want v2", info.Signature())
+	}
+
+	if _, err := parseS3URI("s3://" + testS3Creds + "my-bucket/path?signature=v3"); err != ErrS3InvalidSignature {
+		t.Errorf("err = %v, want ErrS3InvalidSignature", err)
+	}
+}
+
+func TestParseS3URISSEModes(t *testing.T) {
+	info, err := parseS3URI("s3://" + testS3Creds + "my-bucket/path?sse=AES256")
+	if err != nil {
+		t.Fatalf("parseS3URI: %v", err)
+	}
+	if info.SSEMode() != sseAES256 {
+		t.Errorf("SSEMode() = %q, want %q", info.SSEMode(), sseAES256)
+	}
+
+	info, err = parseS3URI("s3://" + testS3Creds + "my-bucket/path?sse=aws:kms&kms_key_id=alias/my-key")
+	if err != nil {
+		t.Fatalf("parseS3URI: %v", err)
+	}
+	if info.SSEMode() != sseKMS {
+		t.Errorf("SSEMode() = %q, want %q", info.SSEMode(), sseKMS)
+	}
+	if info.SSEKMSKeyID() != "alias/my-key" {
+		t.Errorf("SSEKMSKeyID() = %q, want %q", info.SSEKMSKeyID(), "alias/my-key")
+	}
+
+	customerKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	info, err = parseS3URI("s3://" + testS3Creds + "my-bucket/path?sse=C&sse_customer_key=" + customerKey)
+	if err != nil {
+		t.Fatalf("parseS3URI: %v", err)
+	}
+	if info.SSEMode() != sseC {
+		t.Errorf("SSEMode() = %q, want %q", info.SSEMode(), sseC)
+	}
+	wantDigest := md5.Sum(info.SSECustomerKey())
+	if info.SSECustomerKeyMD5() != base64.StdEncoding.EncodeToString(wantDigest[:]) {
+		t.Errorf("SSECustomerKeyMD5() does not match the digest of SSECustomerKey()")
+	}
+}
+
+func TestParseS3URISSEValidation(t *testing.T) {
+	customerKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+	cases := []struct {
+		name    string
+		query   string
+		wantErr error
+	}{
+		{"unknown mode", "?sse=bogus", ErrS3InvalidSSE},
+		{"C without key", "?sse=C", ErrS3SSECMissingKey},
+		{"kms_key_id without sse", "?kms_key_id=alias/my-key", ErrS3SSEConflictingOptions},
+		{"sse_customer_key without sse", "?sse_customer_key=" + customerKey, ErrS3SSEConflictingOptions},
+		{"kms_key_id with sse=C", "?sse=C&sse_customer_key=" + customerKey + "&kms_key_id=alias/my-key", ErrS3SSEConflictingOptions},
+		{"sse_customer_key with sse=aws:kms", "?sse=aws:kms&sse_customer_key=" + customerKey, ErrS3SSEConflictingOptions},
+		{"sse_customer_key with sse=AES256", "?sse=AES256&sse_customer_key=" + customerKey, ErrS3SSEConflictingOptions},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := parseS3URI("s3://" + testS3Creds + "my-bucket/path" + c.query)
+			if err != c.wantErr {
+				t.Errorf("err = %v, want %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"64MB", 64 * 1024 * 1024, false},
+		{"2GB", 2 * 1024 * 1024 * 1024, false},
+		{"512KB", 512 * 1024, false},
+		{"64mb", 64 * 1024 * 1024, false},
+		{"0", 0, true},
+		{"-1MB", 0, true},
+		{"not-a-size", 0, true},
+		{"", 0, true},
+	}
+
+	for _, c := range cases {
+		got, err := parseByteSize(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseByteSize(%q) = %d, <nil>, want an error", c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseByteSize(%q) unexpected error: %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseS3URIPartSizeAndConcurrency(t *testing.T) {
+	info, err := parseS3URI("s3://" + testS3Creds + "my-bucket/path?part_size=16MB&concurrency=4")
+	if err != nil {
+		t.Fatalf("parseS3URI: %v", err)
+	}
+	if info.partSize != 16*1024*1024 {
+		t.Errorf("partSize = %d, want %d", info.partSize, 16*1024*1024)
+	}
+	if info.concurrency != 4 {
+		t.Errorf("concurrency = %d, want 4", info.concurrency)
+	}
+
+	if _, err := parseS3URI("s3://" + testS3Creds + "my-bucket/path?concurrency=0"); err != ErrS3InvalidConcurrency {
+		t.Errorf("err = %v, want ErrS3InvalidConcurrency", err)
+	}
+	if _, err := parseS3URI("s3://" + testS3Creds + "my-bucket/path?part_size=bogus"); err != ErrS3InvalidPartSize {
+		t.Errorf("err = %v, want ErrS3InvalidPartSize", err)
+	}
+}